@@ -0,0 +1,70 @@
+package main
+
+import (
+	"io/ioutil"
+
+	"github.com/juju/errors"
+	"github.com/pingcap/tidb-binlog/drainer/checkpoint"
+)
+
+// checkpointDumpParams holds the parsed arguments for the `binlogctl
+// checkpoint` subcommand, which can export a checkpoint to a CSV file and
+// import it back into a backend built by checkpoint.NewCheckPoint. Only the
+// "flash" (ClickHouse) backend is implemented, so --to-backend accepts no
+// other type.
+type checkpointDumpParams struct {
+	fromCfg   *checkpoint.Config
+	toBackend string
+	file      string
+	destroy   bool
+}
+
+// dumpCheckpoint exports the checkpoint described by p.fromCfg to p.file as
+// CSV. When p.destroy is set, the source row is removed once the export has
+// been confirmed written to disk.
+func dumpCheckpoint(p *checkpointDumpParams) error {
+	cp, err := checkpoint.NewCheckPoint(p.fromCfg.CheckPointType, p.fromCfg)
+	if err != nil {
+		return errors.Trace(err)
+	}
+
+	data, err := cp.Serialize()
+	if err != nil {
+		return errors.Trace(err)
+	}
+
+	if err := ioutil.WriteFile(p.file, data, 0644); err != nil {
+		return errors.Trace(err)
+	}
+
+	if p.destroy {
+		destroyer, ok := cp.(interface{ Destroy() error })
+		if !ok {
+			return errors.Errorf("%s checkpoint backend does not support --destroy", p.fromCfg.CheckPointType)
+		}
+		if err := destroyer.Destroy(); err != nil {
+			return errors.Trace(err)
+		}
+	}
+
+	return nil
+}
+
+// restoreCheckpoint imports the CSV at p.file into the backend named by
+// p.toBackend, using p.fromCfg for connection details.
+func restoreCheckpoint(p *checkpointDumpParams) error {
+	data, err := ioutil.ReadFile(p.file)
+	if err != nil {
+		return errors.Trace(err)
+	}
+
+	cfg := *p.fromCfg
+	cfg.CheckPointType = p.toBackend
+
+	cp, err := checkpoint.NewCheckPoint(cfg.CheckPointType, &cfg)
+	if err != nil {
+		return errors.Trace(err)
+	}
+
+	return errors.Trace(cp.Deserialize(data))
+}
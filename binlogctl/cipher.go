@@ -0,0 +1,64 @@
+package main
+
+import (
+	"encoding/hex"
+	"io/ioutil"
+	"strings"
+
+	"github.com/juju/errors"
+	"github.com/pingcap/tidb-binlog/drainer/checkpoint"
+)
+
+// loadCipherInfo builds a checkpoint.CipherInfo from the --cipher-algorithm/
+// --active-key-file/--retired-key-files flags. Keys are read from disk as
+// hex text rather than taken as flag values directly, so they never show up
+// in the process argument list (e.g. `ps`). An empty algorithm disables
+// encryption and returns a nil CipherInfo.
+func loadCipherInfo(algorithm, activeKeyFile, retiredKeyFiles string) (*checkpoint.CipherInfo, error) {
+	if algorithm == "" {
+		return nil, nil
+	}
+
+	activeKey, err := readHexKeyFile(activeKeyFile)
+	if err != nil {
+		return nil, errors.Annotate(err, "reading --active-key-file")
+	}
+
+	var retiredKeys [][]byte
+	for _, f := range strings.Split(retiredKeyFiles, ",") {
+		f = strings.TrimSpace(f)
+		if f == "" {
+			continue
+		}
+		key, err := readHexKeyFile(f)
+		if err != nil {
+			return nil, errors.Annotatef(err, "reading --retired-key-files entry %q", f)
+		}
+		retiredKeys = append(retiredKeys, key)
+	}
+
+	return &checkpoint.CipherInfo{
+		CipherAlgorithm: algorithm,
+		ActiveKey:       activeKey,
+		RetiredKeys:     retiredKeys,
+	}, nil
+}
+
+// readHexKeyFile reads path and hex-decodes its (trimmed) contents into a key.
+func readHexKeyFile(path string) ([]byte, error) {
+	if path == "" {
+		return nil, errors.New("no key file given")
+	}
+
+	raw, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+
+	key, err := hex.DecodeString(strings.TrimSpace(string(raw)))
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+
+	return key, nil
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"flag"
+	"os"
+
+	"github.com/juju/errors"
+	"github.com/ngaut/log"
+	"github.com/pingcap/tidb-binlog/drainer/checkpoint"
+)
+
+func main() {
+	var (
+		clusterID      = flag.Uint64("cluster-id", 0, "cluster ID of the checkpoint to operate on")
+		checkpointType = flag.String("checkpoint-type", "flash", "checkpoint backend type")
+		dbHost         = flag.String("db-host", "127.0.0.1", "checkpoint backend host")
+		dbPort         = flag.Int("db-port", 9000, "checkpoint backend port")
+		dbUser         = flag.String("db-user", "", "checkpoint backend user")
+		dbPassword     = flag.String("db-password", "", "checkpoint backend password")
+		schema         = flag.String("schema", "tidb_binlog", "checkpoint schema")
+		table          = flag.String("table", "checkpoint", "checkpoint table")
+
+		cipherAlgorithm = flag.String("cipher-algorithm", "", "checkpoint at-rest encryption algorithm (aes128-ctr/aes192-ctr/aes256-ctr); empty disables encryption")
+		activeKeyFile   = flag.String("active-key-file", "", "file containing the hex-encoded active encryption key; required if --cipher-algorithm is set")
+		retiredKeyFiles = flag.String("retired-key-files", "", "comma-separated files containing hex-encoded retired encryption keys, for decrypting checkpoints saved before a key rotation")
+		enableHistory   = flag.Bool("enable-history", false, "the checkpoint was saved with its history table enabled")
+
+		file          = flag.String("file", "", "CSV file to dump the checkpoint to, or restore it from")
+		toBackend     = flag.String("to-backend", "", "restore: checkpoint backend type to import --file into")
+		destroy       = flag.Bool("destroy", false, "dump: remove the source checkpoint row once the export is written")
+		restore       = flag.Bool("restore", false, "import --file into --to-backend instead of dumping the checkpoint to --file")
+		verifyCheckpt = flag.Bool("verify-checkpoint", false, "load and verify the checkpoint without starting a drainer")
+	)
+	flag.Parse()
+
+	cipherInfo, err := loadCipherInfo(*cipherAlgorithm, *activeKeyFile, *retiredKeyFiles)
+	if err != nil {
+		log.Errorf("binlogctl checkpoint command failed: %v", errors.ErrorStack(err))
+		os.Exit(1)
+	}
+
+	cfg := &checkpoint.Config{
+		ClusterID:      *clusterID,
+		CheckPointType: *checkpointType,
+		Db: &checkpoint.DBConfig{
+			Host:     *dbHost,
+			Port:     *dbPort,
+			User:     *dbUser,
+			Password: *dbPassword,
+		},
+		Schema:        *schema,
+		Table:         *table,
+		CipherInfo:    cipherInfo,
+		EnableHistory: *enableHistory,
+	}
+
+	switch {
+	case *verifyCheckpt:
+		err = verifyCheckpoint(cfg)
+	case *restore:
+		err = restoreCheckpoint(&checkpointDumpParams{fromCfg: cfg, toBackend: *toBackend, file: *file})
+	default:
+		err = dumpCheckpoint(&checkpointDumpParams{fromCfg: cfg, toBackend: *toBackend, file: *file, destroy: *destroy})
+	}
+
+	if err != nil {
+		log.Errorf("binlogctl checkpoint command failed: %v", errors.ErrorStack(err))
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,27 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/juju/errors"
+	"github.com/pingcap/tidb-binlog/drainer/checkpoint"
+)
+
+// verifyCheckpoint implements the `--verify-checkpoint` subcommand: it reads
+// the checkpoint for cfg's cluster ID and runs it through the same
+// decrypt/integrity-verify path as a normal Load, without consuming the
+// clean-shutdown marker or starting the history compactor, so operators can
+// sanity check a checkpoint before a drainer starts. Constructing the
+// backend still creates its schema and tables if they are missing.
+func verifyCheckpoint(cfg *checkpoint.Config) error {
+	cfg.ReadOnly = true
+
+	cp, err := checkpoint.NewCheckPoint(cfg.CheckPointType, cfg)
+	if err != nil {
+		return errors.Trace(err)
+	}
+
+	ts, poss := cp.Pos()
+	fmt.Printf("checkpoint OK: clusterid=%d commitTS=%d positions=%+v\n", cfg.ClusterID, ts, poss)
+	return nil
+}
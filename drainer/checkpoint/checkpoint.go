@@ -0,0 +1,129 @@
+package checkpoint
+
+import (
+	"context"
+	"time"
+
+	"github.com/juju/errors"
+	pb "github.com/pingcap/tipb/go-binlog"
+)
+
+// CheckPoint is the interface a drainer checkpoint backend must implement so
+// binlog positions survive a restart. Backend-specific extras (sync points,
+// history, encryption, ...) are exposed through narrower optional interfaces
+// below and reached via a type assertion, so adding one never forces every
+// backend to implement it.
+type CheckPoint interface {
+	// Save saves the CheckPoint
+	Save(ts int64, poss map[string]pb.Pos) error
+	// Load loads the CheckPoint
+	Load() error
+	// Check returns whether the checkpoint should be saved
+	Check(ts int64, poss map[string]pb.Pos) bool
+	// Pos returns the current checkpoint position
+	Pos() (int64, map[string]pb.Pos)
+	// Serialize exports the current checkpoint position (e.g. for dumping
+	// to disk and migrating it to another backend).
+	Serialize() ([]byte, error)
+	// Deserialize restores a checkpoint position previously produced by
+	// Serialize and persists it as the current checkpoint.
+	Deserialize(data []byte) error
+	// Close flushes any pending state, marks the shutdown clean so the next
+	// Load can skip a redundant safe-CP replay, and releases the backend's
+	// connection. Callers must call Close during a graceful shutdown.
+	Close(ctx context.Context) error
+	// Stats reports point-in-time checkpoint metrics for Prometheus
+	// scraping.
+	Stats() Stats
+	String() string
+}
+
+// NewCheckPoint constructs a CheckPoint backend of the given type, e.g.
+// "flash". An empty type defaults to "flash", the only backend currently
+// implemented.
+func NewCheckPoint(tp string, cfg *Config) (CheckPoint, error) {
+	if cfg == nil {
+		cfg = new(Config)
+	}
+	cfg.CheckPointType = tp
+
+	switch tp {
+	case "", "flash":
+		return newFlash(cfg)
+	default:
+		return nil, errors.NotSupportedf("checkpoint type %q", tp)
+	}
+}
+
+// SyncPointLoader is implemented by backends that maintain a sync point
+// table alongside their checkpoint, pairing upstream and downstream
+// timestamps known to be mutually consistent.
+type SyncPointLoader interface {
+	LoadNearestSyncPoint(ts int64) (*SyncPoint, error)
+}
+
+// DBConfig is the configuration of a checkpoint backend's backing database.
+type DBConfig struct {
+	Host     string `toml:"host" json:"host"`
+	Port     int    `toml:"port" json:"port"`
+	User     string `toml:"user" json:"user"`
+	Password string `toml:"password" json:"password"`
+}
+
+// Config is the configuration used to construct a CheckPoint.
+type Config struct {
+	ClusterID       uint64
+	InitialCommitTS int64
+
+	// ReadOnly constructs a CheckPoint whose Load leaves the clean-shutdown
+	// marker in place and which starts no history compactor, for tools
+	// (e.g. binlogctl's --verify-checkpoint) that must not disturb that
+	// state. Construction still creates the schema and tables if they are
+	// missing, and the write methods (Save, Close, ...) are not blocked.
+	ReadOnly bool
+
+	// CheckPointType selects the checkpoint backend NewCheckPoint
+	// constructs, e.g. "flash". Empty defaults to "flash", the only backend
+	// currently implemented.
+	CheckPointType string `toml:"type" json:"type"`
+
+	Db     *DBConfig `toml:"db" json:"db"`
+	Schema string    `toml:"schema" json:"schema"`
+	Table  string    `toml:"table" json:"table"`
+
+	// SyncPointInterval is how often Save additionally records a sync point
+	// pairing the upstream commitTS with a downstream timestamp, so
+	// operators can locate a globally consistent snapshot for point-in-time
+	// checks. Zero uses a 10 minute default.
+	//
+	// When due, the sync point row is written in the same ExecuteSQLs batch
+	// as the checkpoint row. Obtaining the downstream timestamp is
+	// best-effort: if that fails, the failure is logged, the checkpoint is
+	// saved without a sync point, and the next Save tries again. A saved
+	// checkpoint is therefore not guaranteed to have a paired sync point;
+	// use LoadNearestSyncPoint to find the closest one instead of assuming
+	// one exists.
+	SyncPointInterval time.Duration `toml:"sync-point-interval" json:"sync-point-interval"`
+
+	// CipherInfo enables at-rest encryption of the checkpoint payload. A nil
+	// CipherInfo, or one with an empty ActiveKey, leaves checkpoints stored
+	// unencrypted (JSON sealed with its integrity footer).
+	CipherInfo *CipherInfo `toml:"cipher" json:"cipher"`
+
+	// EnableHistory turns on the checkpoint history table, which keeps a
+	// row per saved checkpoint (see HistoryRetention/HistoryKeepVerified)
+	// instead of only the latest one, so an operator can inspect or roll
+	// back to an earlier checkpoint.
+	EnableHistory bool `toml:"enable-history" json:"enable-history"`
+
+	// HistoryRetention is how long a checkpoint history row is kept before
+	// the compactor may delete it, unless it is one of the last
+	// HistoryKeepVerified verified rows. Zero uses a 7 day default. Only
+	// meaningful when EnableHistory is set.
+	HistoryRetention time.Duration `toml:"history-retention" json:"history-retention"`
+
+	// HistoryKeepVerified is how many of the most recent Verified history
+	// rows the compactor always keeps regardless of HistoryRetention. Zero
+	// uses a default of 10. Only meaningful when EnableHistory is set.
+	HistoryKeepVerified int `toml:"history-keep-verified" json:"history-keep-verified"`
+}
@@ -1,35 +1,142 @@
 package checkpoint
 
 import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
 	"database/sql"
 	"encoding/json"
 	"fmt"
+	"io"
+	"strconv"
+	"strings"
 	"sync"
 	"time"
 
 	"github.com/juju/errors"
 	"github.com/ngaut/log"
+	"github.com/pingcap/tidb-binlog/pkg/checkpoint/integrity"
 	"github.com/pingcap/tidb-binlog/pkg/flash"
 	pkgsql "github.com/pingcap/tidb-binlog/pkg/sql"
 	pb "github.com/pingcap/tipb/go-binlog"
 )
 
+// checkpointCipherMagic marks a checkpoint payload as encrypted, allowing
+// Load to fall back to plaintext for backward compatibility.
+var checkpointCipherMagic = []byte("BCPC")
+
+// CipherInfo describes the at-rest encryption scheme for checkpoint
+// payloads, mirroring the scheme BR uses for its checkpoint metadata.
+// CipherAlgorithm must match the byte length of ActiveKey and of every key
+// in RetiredKeys (aes128-ctr: 16 bytes, aes192-ctr: 24 bytes, aes256-ctr: 32
+// bytes). ActiveKey encrypts newly saved checkpoints; RetiredKeys are only
+// used to decrypt checkpoints written before a key rotation.
+type CipherInfo struct {
+	CipherAlgorithm string   `toml:"cipher-algorithm" json:"cipher-algorithm"`
+	ActiveKey       []byte   `toml:"active-key" json:"active-key"`
+	RetiredKeys     [][]byte `toml:"retired-keys" json:"retired-keys"`
+}
+
+// cipherKeyLen maps a CipherAlgorithm name to its required AES key length.
+var cipherKeyLen = map[string]int{
+	"aes128-ctr": 16,
+	"aes192-ctr": 24,
+	"aes256-ctr": 32,
+}
+
+// validateCipherInfo checks that CipherAlgorithm is one of the supported
+// names and that every configured key matches its required length.
+func validateCipherInfo(ci *CipherInfo) error {
+	if ci == nil || len(ci.ActiveKey) == 0 {
+		return nil
+	}
+
+	keyLen, ok := cipherKeyLen[ci.CipherAlgorithm]
+	if !ok {
+		return errors.Errorf("unknown cipher algorithm %q, must be one of aes128-ctr/aes192-ctr/aes256-ctr", ci.CipherAlgorithm)
+	}
+
+	if len(ci.ActiveKey) != keyLen {
+		return errors.Errorf("active-key is %d bytes, but %s requires %d", len(ci.ActiveKey), ci.CipherAlgorithm, keyLen)
+	}
+	for i, key := range ci.RetiredKeys {
+		if len(key) != keyLen {
+			return errors.Errorf("retired-keys[%d] is %d bytes, but %s requires %d", i, len(key), ci.CipherAlgorithm, keyLen)
+		}
+	}
+
+	return nil
+}
+
 // FlashCheckPoint is a local savepoint struct for flash
 type FlashCheckPoint struct {
 	sync.RWMutex
 	clusterID       uint64
 	initialCommitTS int64
 
+	// readOnly makes Load leave the clean-shutdown marker in place and keeps
+	// newFlash from starting the history compactor. newFlash still creates
+	// the schema and tables if they are missing, and Save, Close and the
+	// other write methods are not blocked. Set via Config.ReadOnly.
+	readOnly bool
+
 	db       *sql.DB
 	schema   string
 	table    string
 	metaCP   *flash.MetaCheckpoint
 	saveTime time.Time
 
+	syncPointTable    string
+	syncPointInterval time.Duration
+	lastSyncPointTime time.Time
+
+	cipher *CipherInfo
+
+	historyEnabled   bool
+	historyTable     string
+	historyRetain    time.Duration
+	historyKeepLast  int
+	stopCompactor    chan struct{}
+	compactorStopped sync.Once
+
+	shutdownMarkerTable string
+	cleanShutdown       bool
+	lastSaveLatency     time.Duration
+
 	CommitTS  int64             `toml:"commitTS" json:"commitTS"`
 	Positions map[string]pb.Pos `toml:"positions" json:"positions"`
 }
 
+// CheckpointStatus describes the lifecycle of a single historical checkpoint
+// row, analogous to CheckpointStatus in tidb-lightning.
+type CheckpointStatus int
+
+const (
+	// StatusApplied means the downstream has applied up to this checkpoint.
+	// Every history row starts out Applied, since persistCurrent only
+	// writes a row once its checkpoint has actually been saved.
+	StatusApplied CheckpointStatus = iota
+	// StatusVerified means the checkpoint has additionally passed a
+	// consistency check against the downstream.
+	StatusVerified
+	// StatusSuperseded means a newer checkpoint has since been saved;
+	// persistCurrent marks the previously-newest Applied row Superseded
+	// each time it writes a new one.
+	StatusSuperseded
+)
+
+// SyncPoint is a pair of upstream/downstream timestamps that are known to be
+// mutually consistent, written alongside a checkpoint so operators can locate
+// a globally consistent snapshot for point-in-time checks.
+type SyncPoint struct {
+	ClusterID   uint64 `json:"clusterid"`
+	PrimaryTS   int64  `json:"primary_ts"`
+	SecondaryTS int64  `json:"secondary_ts"`
+	SavedAt     int64  `json:"saved_at"`
+}
+
 func checkFlashConfig(cfg *Config) error {
 	if cfg == nil {
 		cfg = new(Config)
@@ -49,6 +156,18 @@ func checkFlashConfig(cfg *Config) error {
 	if cfg.Table == "" {
 		cfg.Table = "checkpoint"
 	}
+	if cfg.SyncPointInterval == 0 {
+		cfg.SyncPointInterval = 10 * time.Minute
+	}
+	if cfg.HistoryRetention == 0 {
+		cfg.HistoryRetention = 7 * 24 * time.Hour
+	}
+	if cfg.HistoryKeepVerified == 0 {
+		cfg.HistoryKeepVerified = 10
+	}
+	if err := validateCipherInfo(cfg.CipherInfo); err != nil {
+		return errors.Trace(err)
+	}
 
 	return nil
 }
@@ -71,13 +190,23 @@ func newFlash(cfg *Config) (CheckPoint, error) {
 	}
 
 	sp := &FlashCheckPoint{
-		db:              db,
-		clusterID:       cfg.ClusterID,
-		initialCommitTS: cfg.InitialCommitTS,
-		schema:          cfg.Schema,
-		table:           cfg.Table,
-		metaCP:          flash.GetInstance(),
-		Positions:       make(map[string]pb.Pos),
+		db:                  db,
+		readOnly:            cfg.ReadOnly,
+		clusterID:           cfg.ClusterID,
+		initialCommitTS:     cfg.InitialCommitTS,
+		schema:              cfg.Schema,
+		table:               cfg.Table,
+		syncPointTable:      "sync_point",
+		syncPointInterval:   cfg.SyncPointInterval,
+		cipher:              cfg.CipherInfo,
+		historyEnabled:      cfg.EnableHistory,
+		historyTable:        "checkpoint_history",
+		historyRetain:       cfg.HistoryRetention,
+		historyKeepLast:     cfg.HistoryKeepVerified,
+		stopCompactor:       make(chan struct{}),
+		shutdownMarkerTable: "checkpoint_shutdown",
+		metaCP:              flash.GetInstance(),
+		Positions:           make(map[string]pb.Pos),
 	}
 
 	sql := fmt.Sprintf("CREATE DATABASE IF NOT EXISTS `%s`", sp.schema)
@@ -95,6 +224,35 @@ func newFlash(cfg *Config) (CheckPoint, error) {
 
 	}
 
+	sql = fmt.Sprintf("ATTACH TABLE IF NOT EXISTS `%s`.`%s`(`clusterid` UInt64, `primary_ts` Int64, `secondary_ts` Int64, `saved_at` Int64) ENGINE MutableMergeTree((`clusterid`, `primary_ts`), 8192)", sp.schema, sp.syncPointTable)
+	_, err = execSQL(db, sql)
+	if err != nil {
+		log.Errorf("Create sync point table error %v", err)
+		return nil, errors.Trace(err)
+	}
+
+	if sp.historyEnabled {
+		sql = fmt.Sprintf(
+			"ATTACH TABLE IF NOT EXISTS `%s`.`%s`(`clusterid` UInt64, `commit_ts` Int64, `saved_at` Int64, `status` UInt8, `checkpoint` String) ENGINE MutableMergeTree((`clusterid`, `commit_ts`), 8192)",
+			sp.schema, sp.historyTable)
+		_, err = execSQL(db, sql)
+		if err != nil {
+			log.Errorf("Create checkpoint history table error %v", err)
+			return nil, errors.Trace(err)
+		}
+
+		if !sp.readOnly {
+			go sp.runHistoryCompactor()
+		}
+	}
+
+	sql = fmt.Sprintf("ATTACH TABLE IF NOT EXISTS `%s`.`%s`(`clusterid` UInt64, `saved_at` Int64) ENGINE MutableMergeTree((`clusterid`), 8192)", sp.schema, sp.shutdownMarkerTable)
+	_, err = execSQL(db, sql)
+	if err != nil {
+		log.Errorf("Create shutdown marker table error %v", err)
+		return nil, errors.Trace(err)
+	}
+
 	err = sp.Load()
 	return sp, errors.Trace(err)
 }
@@ -125,7 +283,37 @@ func (sp *FlashCheckPoint) Load() error {
 		return nil
 	}
 
-	err = json.Unmarshal([]byte(str), sp)
+	decrypted, err := sp.decryptPayload([]byte(str))
+	if err != nil {
+		return errors.Trace(err)
+	}
+
+	raw, verr := integrity.Verify(decrypted)
+	switch {
+	case verr == nil:
+		// sealed and verified, nothing more to do.
+	case errors.Cause(verr) == integrity.ErrNoFooter && json.Valid(decrypted):
+		// Saved before integrity verification existed: the footer is simply
+		// absent, and what's left parses as JSON on its own, so treat the
+		// decrypted bytes as the plaintext body directly. A truncated modern
+		// record can also lose its footer, but won't parse as JSON, so it
+		// falls through to the corruption path below instead.
+		raw = decrypted
+	default:
+		log.Errorf("checkpoint for clusterid %d failed integrity verification: %v", sp.clusterID, verr)
+		if !sp.historyEnabled {
+			return errors.Trace(verr)
+		}
+
+		raw, err = sp.loadNewestVerifiableHistory()
+		if err != nil {
+			log.Errorf("no usable checkpoint history fallback for clusterid %d: %v", sp.clusterID, err)
+			return errors.Trace(verr)
+		}
+		log.Warnf("checkpoint for clusterid %d is corrupted, fell back to the newest verifiable history row", sp.clusterID)
+	}
+
+	err = json.Unmarshal(raw, sp)
 	if err != nil {
 		return errors.Trace(err)
 	}
@@ -133,9 +321,107 @@ func (sp *FlashCheckPoint) Load() error {
 	if sp.CommitTS == 0 {
 		sp.CommitTS = sp.initialCommitTS
 	}
+
+	if err := sp.consumeShutdownMarker(); err != nil {
+		log.Errorf("consume clean shutdown marker error %v", err)
+	}
+
 	return nil
 }
 
+// CleanShutdown reports whether the last shutdown of this checkpoint was
+// clean, i.e. Close ran to completion before the next Load. The drainer's
+// recovery path should check this and skip a redundant safe-CP replay when
+// it is true.
+func (sp *FlashCheckPoint) CleanShutdown() bool {
+	sp.RLock()
+	defer sp.RUnlock()
+
+	return sp.cleanShutdown
+}
+
+// consumeShutdownMarker checks for the "clean shutdown" marker row written
+// by Close, and if present, records that the last shutdown was clean (so
+// callers can skip a redundant safe-CP replay via CleanShutdown) and removes
+// the marker so it only takes effect for the first Load after that
+// shutdown.
+func (sp *FlashCheckPoint) consumeShutdownMarker() error {
+	sql := fmt.Sprintf("SELECT `clusterid` FROM `%s`.`%s` WHERE `clusterid` = %d", sp.schema, sp.shutdownMarkerTable, sp.clusterID)
+	rows, err := querySQL(sp.db, sql)
+	if err != nil {
+		return errors.Trace(err)
+	}
+
+	found := false
+	for rows.Next() {
+		found = true
+	}
+	if !found {
+		return nil
+	}
+
+	sp.cleanShutdown = true
+
+	if sp.readOnly {
+		return nil
+	}
+
+	sql = fmt.Sprintf("ALTER TABLE `%s`.`%s` DELETE WHERE `clusterid` = %d", sp.schema, sp.shutdownMarkerTable, sp.clusterID)
+	_, err = execSQL(sp.db, sql)
+	return errors.Trace(err)
+}
+
+// historyFallbackScanRows bounds how many history rows loadNewestVerifiableHistory
+// will inspect looking for one that still passes integrity verification.
+const historyFallbackScanRows = 5
+
+// loadNewestVerifiableHistory scans the checkpoint history table, newest
+// first, and returns the decrypted, integrity-verified JSON body of the
+// first row that still passes verification, for use when the primary
+// checkpoint row fails integrity verification. A row from the same Save
+// batch as the corrupted primary row can be corrupted too, so this does not
+// stop at the first (newest) candidate; it keeps scanning until one verifies.
+func (sp *FlashCheckPoint) loadNewestVerifiableHistory() ([]byte, error) {
+	if !sp.historyEnabled {
+		return nil, errors.NotFoundf("checkpoint history is not enabled for clusterid %d", sp.clusterID)
+	}
+
+	sql := fmt.Sprintf(
+		"SELECT `checkpoint` FROM `%s`.`%s` WHERE `clusterid` = %d ORDER BY `commit_ts` DESC LIMIT %d",
+		sp.schema, sp.historyTable, sp.clusterID, historyFallbackScanRows)
+	rows, err := querySQL(sp.db, sql)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+
+	var lastErr error
+	for rows.Next() {
+		var raw string
+		if err := rows.Scan(&raw); err != nil {
+			return nil, errors.Trace(err)
+		}
+
+		decrypted, err := sp.decryptPayload([]byte(raw))
+		if err != nil {
+			lastErr = err
+			continue
+		}
+
+		body, err := integrity.Verify(decrypted)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+
+		return body, nil
+	}
+
+	if lastErr == nil {
+		lastErr = errors.NotFoundf("a verifiable checkpoint history row for clusterid %d", sp.clusterID)
+	}
+	return nil, errors.Trace(lastErr)
+}
+
 // Save implements checkpoint.Save interface
 func (sp *FlashCheckPoint) Save(ts int64, poss map[string]pb.Pos) error {
 	sp.Lock()
@@ -163,18 +449,140 @@ func (sp *FlashCheckPoint) Save(ts int64, poss map[string]pb.Pos) error {
 
 	sp.CommitTS = safeTS
 
+	return sp.persistCurrent(safeTS)
+}
+
+// persistCurrent marshals, seals and (if configured) encrypts the in-memory
+// checkpoint, then writes it to the checkpoint table together with, when
+// history is enabled, a history row and, when due, a sync point row in the
+// same batch. When
+// history is enabled, it also marks any older Applied history rows for
+// this cluster Superseded, so ListHistory can tell a row that was replaced
+// by a later save apart from the still-current one. Callers must hold
+// sp.Lock, have already set sp.CommitTS/sp.Positions to the values to
+// persist, and pass that same commitTS as ts for the history and sync
+// point rows.
+func (sp *FlashCheckPoint) persistCurrent(ts int64) error {
 	b, err := json.Marshal(sp)
 	if err != nil {
 		log.Errorf("Json Marshal error %v", err)
 		return errors.Trace(err)
 	}
 
+	b = integrity.Seal(b)
+
+	b, err = sp.encryptPayload(b)
+	if err != nil {
+		log.Errorf("encrypt checkpoint error %v", err)
+		return errors.Trace(err)
+	}
+
 	sql := fmt.Sprintf("IMPORT INTO `%s`.`%s` (`clusterid`, `checkpoint`) VALUES(?, ?)", sp.schema, sp.table)
 	sqls := []string{sql}
 	args := [][]interface{}{{sp.clusterID, b}}
+
+	if sp.historyEnabled {
+		sql := fmt.Sprintf("IMPORT INTO `%s`.`%s` (`clusterid`, `commit_ts`, `saved_at`, `status`, `checkpoint`) VALUES(?, ?, ?, ?, ?)", sp.schema, sp.historyTable)
+		sqls = append(sqls, sql)
+		args = append(args, []interface{}{sp.clusterID, ts, sp.saveTime.Unix(), StatusApplied, b})
+	}
+
+	// When due, the sync point insert is appended into the same sqls/args
+	// batch as the checkpoint (and history) rows above, so the single
+	// ExecuteSQLs call below writes the sync point together with its
+	// checkpoint. If markSyncPoint fails, the checkpoint is saved without a
+	// sync point. markSyncPoint itself is a plain read against the
+	// ClickHouse target and stays outside the batch.
+	writeSyncPoint := time.Since(sp.lastSyncPointTime) >= sp.syncPointInterval
+	var secondaryTS int64
+	if writeSyncPoint {
+		var err error
+		secondaryTS, err = sp.markSyncPoint()
+		if err != nil {
+			log.Errorf("mark sync point for clusterid %d error (best-effort, will retry on next save) %v", sp.clusterID, err)
+			writeSyncPoint = false
+		}
+	}
+	if writeSyncPoint {
+		sql := fmt.Sprintf("IMPORT INTO `%s`.`%s` (`clusterid`, `primary_ts`, `secondary_ts`, `saved_at`) VALUES(?, ?, ?, ?)", sp.schema, sp.syncPointTable)
+		sqls = append(sqls, sql)
+		args = append(args, []interface{}{sp.clusterID, ts, secondaryTS, sp.saveTime.Unix()})
+	}
+
 	err = pkgsql.ExecuteSQLs(sp.db, sqls, args, false)
+	sp.lastSaveLatency = time.Since(sp.saveTime)
+	if err != nil {
+		return errors.Trace(err)
+	}
 
-	return errors.Trace(err)
+	if writeSyncPoint {
+		sp.lastSyncPointTime = sp.saveTime
+	}
+
+	if sp.historyEnabled {
+		sql := fmt.Sprintf(
+			"ALTER TABLE `%s`.`%s` UPDATE `status` = %d WHERE `clusterid` = %d AND `commit_ts` < %d AND `status` = %d",
+			sp.schema, sp.historyTable, StatusSuperseded, sp.clusterID, ts, StatusApplied)
+		if _, err := execSQL(sp.db, sql); err != nil {
+			log.Errorf("mark superseded checkpoint history rows for clusterid %d error %v", sp.clusterID, err)
+		}
+	}
+
+	return nil
+}
+
+// markSyncPoint queries the ClickHouse target's own clock to obtain a
+// downstream timestamp that is consistent with, and monotonic relative to,
+// the checkpoint being saved. The caller is responsible for writing it out
+// alongside the checkpoint; markSyncPoint itself issues no write.
+func (sp *FlashCheckPoint) markSyncPoint() (int64, error) {
+	rows, err := querySQL(sp.db, "SELECT toUnixTimestamp64Milli(now64(3))")
+	if err != nil {
+		return 0, errors.Trace(err)
+	}
+
+	var secondaryTS int64
+	for rows.Next() {
+		if err := rows.Scan(&secondaryTS); err != nil {
+			return 0, errors.Trace(err)
+		}
+	}
+
+	return secondaryTS, nil
+}
+
+// LoadNearestSyncPoint returns the sync point whose primary_ts is the
+// closest to ts without exceeding it, so operators can locate a globally
+// consistent upstream/downstream pair for point-in-time checks. Sync points
+// are written best-effort and on a coarser interval than checkpoints (see
+// Config.SyncPointInterval), so callers must not assume one exists for
+// every checkpoint; NotFound means no sync point at or before ts has been
+// recorded yet, not that the checkpoint itself is invalid.
+func (sp *FlashCheckPoint) LoadNearestSyncPoint(ts int64) (*SyncPoint, error) {
+	sp.RLock()
+	defer sp.RUnlock()
+
+	sql := fmt.Sprintf(
+		"SELECT `primary_ts`, `secondary_ts`, `saved_at` FROM `%s`.`%s` WHERE `clusterid` = %d AND `primary_ts` <= %d ORDER BY `primary_ts` DESC LIMIT 1",
+		sp.schema, sp.syncPointTable, sp.clusterID, ts)
+	rows, err := querySQL(sp.db, sql)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+
+	point := &SyncPoint{ClusterID: sp.clusterID}
+	found := false
+	for rows.Next() {
+		if err := rows.Scan(&point.PrimaryTS, &point.SecondaryTS, &point.SavedAt); err != nil {
+			return nil, errors.Trace(err)
+		}
+		found = true
+	}
+	if !found {
+		return nil, errors.NotFoundf("sync point for clusterid %d before ts %d", sp.clusterID, ts)
+	}
+
+	return point, nil
 }
 
 // Check implements CheckPoint.Check interface
@@ -207,4 +615,336 @@ func (sp *FlashCheckPoint) Pos() (int64, map[string]pb.Pos) {
 func (sp *FlashCheckPoint) String() string {
 	ts, poss := sp.Pos()
 	return fmt.Sprintf("binlog commitTS = %d and positions = %+v", ts, poss)
-}
\ No newline at end of file
+}
+
+// HistoryEntry is a single row from the checkpoint history table.
+type HistoryEntry struct {
+	CommitTS int64
+	SavedAt  int64
+	Status   CheckpointStatus
+}
+
+// ListHistory returns up to limit of the most recent checkpoint history
+// rows for clusterID, newest first.
+func (sp *FlashCheckPoint) ListHistory(clusterID uint64, limit int) ([]HistoryEntry, error) {
+	sql := fmt.Sprintf(
+		"SELECT `commit_ts`, `saved_at`, `status` FROM `%s`.`%s` WHERE `clusterid` = %d ORDER BY `commit_ts` DESC LIMIT %d",
+		sp.schema, sp.historyTable, clusterID, limit)
+	rows, err := querySQL(sp.db, sql)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+
+	var entries []HistoryEntry
+	for rows.Next() {
+		var e HistoryEntry
+		if err := rows.Scan(&e.CommitTS, &e.SavedAt, &e.Status); err != nil {
+			return nil, errors.Trace(err)
+		}
+		entries = append(entries, e)
+	}
+
+	return entries, nil
+}
+
+// RollbackTo restores the in-memory checkpoint to the historical row saved
+// at commitTS, marking it Verified so the retention compactor keeps it, and
+// writes it back out as the current checkpoint. It lets an operator recover
+// after a bad downstream apply without losing history.
+func (sp *FlashCheckPoint) RollbackTo(commitTS int64) error {
+	sp.Lock()
+	defer sp.Unlock()
+
+	sql := fmt.Sprintf(
+		"SELECT `checkpoint` FROM `%s`.`%s` WHERE `clusterid` = %d AND `commit_ts` = %d",
+		sp.schema, sp.historyTable, sp.clusterID, commitTS)
+	rows, err := querySQL(sp.db, sql)
+	if err != nil {
+		return errors.Trace(err)
+	}
+
+	var raw string
+	found := false
+	for rows.Next() {
+		if err := rows.Scan(&raw); err != nil {
+			return errors.Trace(err)
+		}
+		found = true
+	}
+	if !found {
+		return errors.NotFoundf("checkpoint history row for clusterid %d commitTS %d", sp.clusterID, commitTS)
+	}
+
+	plain, err := sp.decryptPayload([]byte(raw))
+	if err != nil {
+		return errors.Trace(err)
+	}
+	plain, err = integrity.Verify(plain)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if err := json.Unmarshal(plain, sp); err != nil {
+		return errors.Trace(err)
+	}
+
+	sql = fmt.Sprintf("IMPORT INTO `%s`.`%s` (`clusterid`, `checkpoint`) VALUES(?, ?)", sp.schema, sp.table)
+	args := [][]interface{}{{sp.clusterID, []byte(raw)}}
+	if err := pkgsql.ExecuteSQLs(sp.db, []string{sql}, args, false); err != nil {
+		return errors.Trace(err)
+	}
+
+	sql = fmt.Sprintf(
+		"ALTER TABLE `%s`.`%s` UPDATE `status` = %d WHERE `clusterid` = %d AND `commit_ts` = %d",
+		sp.schema, sp.historyTable, StatusVerified, sp.clusterID, commitTS)
+	_, err = execSQL(sp.db, sql)
+	return errors.Trace(err)
+}
+
+// runHistoryCompactor periodically trims checkpoint history rows older than
+// historyRetain, always keeping the last historyKeepLast verified
+// checkpoints regardless of age. It stops as soon as Close closes
+// sp.stopCompactor, so it never fires against sp.db after Close has closed
+// it.
+func (sp *FlashCheckPoint) runHistoryCompactor() {
+	ticker := time.NewTicker(sp.historyRetain / 10)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-sp.stopCompactor:
+			return
+		case <-ticker.C:
+			// Close closes stopCompactor before taking sp.Lock to close
+			// sp.db, so holding sp.RLock here and re-checking the stop
+			// channel keeps the delete from running on a closed db.
+			sp.RLock()
+			select {
+			case <-sp.stopCompactor:
+				sp.RUnlock()
+				return
+			default:
+			}
+			cutoff := time.Now().Add(-sp.historyRetain).Unix()
+			sql := fmt.Sprintf(
+				"ALTER TABLE `%s`.`%s` DELETE WHERE `clusterid` = %d AND `saved_at` < %d AND `commit_ts` NOT IN (SELECT `commit_ts` FROM `%s`.`%s` WHERE `clusterid` = %d AND `status` = %d ORDER BY `commit_ts` DESC LIMIT %d)",
+				sp.schema, sp.historyTable, sp.clusterID, cutoff,
+				sp.schema, sp.historyTable, sp.clusterID, StatusVerified, sp.historyKeepLast)
+			if _, err := execSQL(sp.db, sql); err != nil {
+				log.Errorf("compact checkpoint history error %v", err)
+			}
+			sp.RUnlock()
+		}
+	}
+}
+
+// Serialize implements CheckPoint.Serialize interface. It returns the
+// current checkpoint position as a CSV row of `commitTS,nodeID,suffix,offset`
+// (one line per node position), so it can be dumped and later migrated to
+// any other backend via Deserialize.
+func (sp *FlashCheckPoint) Serialize() ([]byte, error) {
+	sp.RLock()
+	defer sp.RUnlock()
+
+	var buf bytes.Buffer
+	for nodeID, pos := range sp.Positions {
+		fmt.Fprintf(&buf, "%d,%s,%s,%d\n", sp.CommitTS, nodeID, pos.Suffix, pos.Offset)
+	}
+	if len(sp.Positions) == 0 {
+		fmt.Fprintf(&buf, "%d,,,\n", sp.CommitTS)
+	}
+
+	return buf.Bytes(), nil
+}
+
+// Deserialize implements CheckPoint.Deserialize interface, restoring a
+// checkpoint position previously produced by Serialize and persisting it as
+// the current checkpoint for this backend. It writes directly via
+// persistCurrent rather than Save: Save only flushes when metaCP has a
+// pending safe CP queued up by a live drainer's repeated Check() calls,
+// which a freshly constructed FlashCheckPoint used for a one-shot restore
+// never has, so routing through Save would silently no-op here.
+func (sp *FlashCheckPoint) Deserialize(data []byte) error {
+	commitTS, positions, err := parseCheckpointCSV(data)
+	if err != nil {
+		return errors.Trace(err)
+	}
+
+	sp.Lock()
+	defer sp.Unlock()
+
+	sp.CommitTS = commitTS
+	sp.Positions = positions
+	sp.saveTime = time.Now()
+
+	return sp.persistCurrent(commitTS)
+}
+
+// parseCheckpointCSV parses the `commitTS,nodeID,suffix,offset` CSV rows
+// produced by Serialize back into a commitTS and a position map.
+func parseCheckpointCSV(data []byte) (int64, map[string]pb.Pos, error) {
+	positions := make(map[string]pb.Pos)
+	var commitTS int64
+
+	for _, line := range strings.Split(strings.TrimSpace(string(data)), "\n") {
+		if line == "" {
+			continue
+		}
+		fields := strings.Split(line, ",")
+		if len(fields) != 4 {
+			return 0, nil, errors.Errorf("malformed checkpoint csv row %q", line)
+		}
+
+		ts, err := strconv.ParseInt(fields[0], 10, 64)
+		if err != nil {
+			return 0, nil, errors.Trace(err)
+		}
+		commitTS = ts
+
+		if fields[1] == "" {
+			continue
+		}
+		offset, err := strconv.ParseInt(fields[3], 10, 64)
+		if err != nil {
+			return 0, nil, errors.Trace(err)
+		}
+		positions[fields[1]] = pb.Pos{Suffix: fields[2], Offset: offset}
+	}
+
+	return commitTS, positions, nil
+}
+
+// Stats reports point-in-time checkpoint metrics for Prometheus scraping.
+type Stats struct {
+	OpenConnections     int
+	LastSaveLatency     time.Duration
+	PendingCPQueueDepth int
+}
+
+// Stats implements CheckPoint.Stats interface.
+func (sp *FlashCheckPoint) Stats() Stats {
+	sp.RLock()
+	defer sp.RUnlock()
+
+	dbStats := sp.db.Stats()
+	return Stats{
+		OpenConnections:     dbStats.OpenConnections,
+		LastSaveLatency:     sp.lastSaveLatency,
+		PendingCPQueueDepth: sp.metaCP.PendingCount(),
+	}
+}
+
+// Close implements CheckPoint.Close interface: it flushes any pending
+// metaCP state, stops the history compactor goroutine, writes a "clean
+// shutdown" marker row so the next Load can skip the redundant safe-CP
+// replay, and releases the ClickHouse connection.
+func (sp *FlashCheckPoint) Close(ctx context.Context) error {
+	if forceSave, ok, safeTS, safePoss := sp.metaCP.PopSafeCP(); forceSave || ok {
+		if err := sp.Save(safeTS, safePoss); err != nil {
+			return errors.Trace(err)
+		}
+	}
+
+	if sp.historyEnabled {
+		sp.compactorStopped.Do(func() { close(sp.stopCompactor) })
+	}
+
+	sp.Lock()
+	defer sp.Unlock()
+
+	sql := fmt.Sprintf("IMPORT INTO `%s`.`%s` (`clusterid`, `saved_at`) VALUES(?, ?)", sp.schema, sp.shutdownMarkerTable)
+	args := [][]interface{}{{sp.clusterID, time.Now().Unix()}}
+	if err := pkgsql.ExecuteSQLs(sp.db, []string{sql}, args, false); err != nil {
+		log.Errorf("write clean shutdown marker error %v", err)
+	}
+
+	return errors.Trace(sp.db.Close())
+}
+
+// Destroy removes the checkpoint row for this cluster, for use after an
+// operator has confirmed a successful Serialize export during a backend
+// migration.
+func (sp *FlashCheckPoint) Destroy() error {
+	sp.Lock()
+	defer sp.Unlock()
+
+	sql := fmt.Sprintf("ALTER TABLE `%s`.`%s` DELETE WHERE `clusterid` = %d", sp.schema, sp.table, sp.clusterID)
+	_, err := execSQL(sp.db, sql)
+	return errors.Trace(err)
+}
+
+// encryptPayload encrypts b with the active key, prepending the magic
+// header and a random IV. If no cipher is configured, b is returned as-is.
+func (sp *FlashCheckPoint) encryptPayload(b []byte) ([]byte, error) {
+	if sp.cipher == nil || len(sp.cipher.ActiveKey) == 0 {
+		return b, nil
+	}
+
+	block, err := aes.NewCipher(sp.cipher.ActiveKey)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+
+	out := make([]byte, len(checkpointCipherMagic)+aes.BlockSize+len(b))
+	copy(out, checkpointCipherMagic)
+	iv := out[len(checkpointCipherMagic) : len(checkpointCipherMagic)+aes.BlockSize]
+	if _, err := io.ReadFull(rand.Reader, iv); err != nil {
+		return nil, errors.Trace(err)
+	}
+
+	stream := cipher.NewCTR(block, iv)
+	stream.XORKeyStream(out[len(checkpointCipherMagic)+aes.BlockSize:], b)
+
+	return out, nil
+}
+
+// decryptPayload detects the magic header written by encryptPayload and
+// decrypts accordingly, trying the active key followed by any retired keys
+// so a rotated key can still decrypt older checkpoints. Payloads without the
+// magic header are assumed to be plaintext, for backward compatibility with
+// checkpoints saved before encryption was enabled.
+//
+// Save always seals the checkpoint JSON with integrity.Seal before
+// encrypting it, so the plaintext under a key is either a sealed record
+// (integrity.HasFooter) or, for checkpoints saved before integrity
+// verification existed, bare JSON (json.Valid). Either is accepted as
+// evidence the right key was found; the caller's integrity.Verify/
+// json.Unmarshal is what ultimately decides correctness.
+func (sp *FlashCheckPoint) decryptPayload(b []byte) ([]byte, error) {
+	if sp.cipher == nil || len(b) < len(checkpointCipherMagic) || string(b[:len(checkpointCipherMagic)]) != string(checkpointCipherMagic) {
+		return b, nil
+	}
+
+	if len(b) < len(checkpointCipherMagic)+aes.BlockSize {
+		return nil, errors.Errorf("encrypted checkpoint payload is too short")
+	}
+
+	iv := b[len(checkpointCipherMagic) : len(checkpointCipherMagic)+aes.BlockSize]
+	ciphertext := b[len(checkpointCipherMagic)+aes.BlockSize:]
+
+	keys := make([][]byte, 0, 1+len(sp.cipher.RetiredKeys))
+	if len(sp.cipher.ActiveKey) > 0 {
+		keys = append(keys, sp.cipher.ActiveKey)
+	}
+	keys = append(keys, sp.cipher.RetiredKeys...)
+
+	var lastErr error
+	for _, key := range keys {
+		block, err := aes.NewCipher(key)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+
+		plain := make([]byte, len(ciphertext))
+		cipher.NewCTR(block, iv).XORKeyStream(plain, ciphertext)
+
+		if integrity.HasFooter(plain) || json.Valid(plain) {
+			return plain, nil
+		}
+	}
+
+	if lastErr == nil {
+		lastErr = errors.Errorf("no usable decryption key for encrypted checkpoint")
+	}
+	return nil, errors.Trace(lastErr)
+}
@@ -0,0 +1,74 @@
+package checkpoint
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestEncryptDecryptPayloadRoundTrip(t *testing.T) {
+	sp := &FlashCheckPoint{
+		cipher: &CipherInfo{
+			CipherAlgorithm: "aes128-ctr",
+			ActiveKey:       []byte("0123456789abcdef"),
+		},
+	}
+
+	plain := []byte(`{"commitTS":1,"positions":{}}`)
+	ciphertext, err := sp.encryptPayload(plain)
+	if err != nil {
+		t.Fatalf("encryptPayload returned error: %v", err)
+	}
+	if bytes.Equal(ciphertext, plain) {
+		t.Fatal("encryptPayload did not encrypt the payload")
+	}
+
+	got, err := sp.decryptPayload(ciphertext)
+	if err != nil {
+		t.Fatalf("decryptPayload returned error: %v", err)
+	}
+	if !bytes.Equal(got, plain) {
+		t.Fatalf("decryptPayload(encryptPayload(plain)) = %q, want %q", got, plain)
+	}
+}
+
+func TestDecryptPayloadNoCipherIsPassthrough(t *testing.T) {
+	sp := &FlashCheckPoint{}
+
+	plain := []byte(`{"commitTS":1,"positions":{}}`)
+	got, err := sp.decryptPayload(plain)
+	if err != nil {
+		t.Fatalf("decryptPayload returned error: %v", err)
+	}
+	if !bytes.Equal(got, plain) {
+		t.Fatalf("decryptPayload(plain) = %q, want %q unchanged", got, plain)
+	}
+}
+
+func TestDecryptPayloadRetiredKeyRotation(t *testing.T) {
+	retired := []byte("retiredkey123456")
+	active := []byte("activekey1234567")
+
+	old := &FlashCheckPoint{
+		cipher: &CipherInfo{CipherAlgorithm: "aes128-ctr", ActiveKey: retired},
+	}
+	plain := []byte(`{"commitTS":1,"positions":{}}`)
+	ciphertext, err := old.encryptPayload(plain)
+	if err != nil {
+		t.Fatalf("encryptPayload with retired key returned error: %v", err)
+	}
+
+	rotated := &FlashCheckPoint{
+		cipher: &CipherInfo{
+			CipherAlgorithm: "aes128-ctr",
+			ActiveKey:       active,
+			RetiredKeys:     [][]byte{retired},
+		},
+	}
+	got, err := rotated.decryptPayload(ciphertext)
+	if err != nil {
+		t.Fatalf("decryptPayload after key rotation returned error: %v", err)
+	}
+	if !bytes.Equal(got, plain) {
+		t.Fatalf("decryptPayload after key rotation = %q, want %q", got, plain)
+	}
+}
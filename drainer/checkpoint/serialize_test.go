@@ -0,0 +1,59 @@
+package checkpoint
+
+import (
+	"reflect"
+	"testing"
+
+	pb "github.com/pingcap/tipb/go-binlog"
+)
+
+func TestSerializeParseCheckpointCSVRoundTrip(t *testing.T) {
+	sp := &FlashCheckPoint{
+		CommitTS: 42,
+		Positions: map[string]pb.Pos{
+			"node-1": {Suffix: "binlog-0001", Offset: 100},
+		},
+	}
+
+	data, err := sp.Serialize()
+	if err != nil {
+		t.Fatalf("Serialize returned error: %v", err)
+	}
+
+	commitTS, positions, err := parseCheckpointCSV(data)
+	if err != nil {
+		t.Fatalf("parseCheckpointCSV(Serialize()) returned error: %v", err)
+	}
+	if commitTS != sp.CommitTS {
+		t.Fatalf("commitTS = %d, want %d", commitTS, sp.CommitTS)
+	}
+	if !reflect.DeepEqual(positions, sp.Positions) {
+		t.Fatalf("positions = %+v, want %+v", positions, sp.Positions)
+	}
+}
+
+func TestSerializeParseCheckpointCSVRoundTripNoPositions(t *testing.T) {
+	sp := &FlashCheckPoint{CommitTS: 7}
+
+	data, err := sp.Serialize()
+	if err != nil {
+		t.Fatalf("Serialize returned error: %v", err)
+	}
+
+	commitTS, positions, err := parseCheckpointCSV(data)
+	if err != nil {
+		t.Fatalf("parseCheckpointCSV(Serialize()) returned error: %v", err)
+	}
+	if commitTS != sp.CommitTS {
+		t.Fatalf("commitTS = %d, want %d", commitTS, sp.CommitTS)
+	}
+	if len(positions) != 0 {
+		t.Fatalf("positions = %+v, want empty", positions)
+	}
+}
+
+func TestParseCheckpointCSVMalformedRow(t *testing.T) {
+	if _, _, err := parseCheckpointCSV([]byte("1,node-1,binlog-0001\n")); err == nil {
+		t.Fatal("parseCheckpointCSV did not reject a malformed row")
+	}
+}
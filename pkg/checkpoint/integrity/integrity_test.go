@@ -0,0 +1,39 @@
+package integrity
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/juju/errors"
+)
+
+func TestSealVerifyRoundTrip(t *testing.T) {
+	body := []byte(`{"commitTS":1,"positions":{}}`)
+
+	record := Seal(body)
+	got, err := Verify(record)
+	if err != nil {
+		t.Fatalf("Verify(Seal(body)) returned error: %v", err)
+	}
+	if !bytes.Equal(got, body) {
+		t.Fatalf("Verify(Seal(body)) = %q, want %q", got, body)
+	}
+}
+
+func TestVerifyDetectsCorruption(t *testing.T) {
+	record := Seal([]byte(`{"commitTS":1,"positions":{}}`))
+	record[0] ^= 0xff
+
+	if _, err := Verify(record); err == nil {
+		t.Fatal("Verify did not detect a corrupted body")
+	}
+}
+
+func TestVerifyLegacyFooterlessRecord(t *testing.T) {
+	legacy := []byte(`{"commitTS":1,"positions":{}}`)
+
+	_, err := Verify(legacy)
+	if errors.Cause(err) != ErrNoFooter {
+		t.Fatalf("Verify(legacy) error = %v, want ErrNoFooter", err)
+	}
+}